@@ -2,27 +2,182 @@ package main
 
 import (
     "bufio"
+    "encoding/csv"
+    "encoding/json"
     "errors"
     "flag"
     "fmt"
     "io"
+    "io/ioutil"
     "log"
     "math"
     "math/rand"
+    "net/http"
+    _ "net/http/pprof"
     "os"
+    "path/filepath"
     "runtime"
     "runtime/pprof"
     "runtime/trace"
+    "sort"
     "strconv"
     "strings"
     "sync"
     "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var cpuProfile = flag.String("cpu-profile", "", "write cpu profile to `file`")
-var traceLog = flag.String("trace-log", "", "write trace log to `file`")
-var debug = flag.Bool("debug", false, "show debug info")
-var duration = flag.String("duration", "", "max execution time for example 500ms or 60s")
+// solveFlags holds the flags shared by the "solve" and "benchmark"
+// subcommands (benchmark applies them to every input file it runs).
+type solveFlags struct {
+    cpuProfile   string
+    traceLog     string
+    debug        bool
+    duration     string
+    replicas     int
+    swapInterval int
+    output       string
+    metricsAddr  string
+    energyLog    string
+    seed         int64
+    moveMix      string
+}
+
+// registerSeedFlag registers the -seed flag with the help text shared by
+// every subcommand that derives RNGs from a master seed, so "solve" and
+// "benchmark" can't drift apart on wording or default.
+func registerSeedFlag(fs *flag.FlagSet, seed *int64) {
+    fs.Int64Var(seed, "seed", *seed, "master RNG seed; 0 derives a seed from the current time (non-reproducible)")
+}
+
+// registerSolverTuningFlags registers the -replicas, -swap-interval and
+// -move-mix flags shared by "solve" and "benchmark", so the two subcommands'
+// flag wiring can't drift apart. Each *int/*string is read for its current
+// value as the flag default, so callers set defaults by pre-populating the
+// variable rather than repeating a literal here.
+func registerSolverTuningFlags(fs *flag.FlagSet, replicas *int, swapInterval *int, moveMix *string) {
+    fs.IntVar(replicas, "replicas", *replicas, "number of parallel tempering replicas")
+    fs.IntVar(swapInterval, "swap-interval", *swapInterval, "steps between replica-exchange swap attempts")
+    fs.StringVar(moveMix, "move-mix", *moveMix, "relative weights `swap-zone,swap-region,two-opt,or-opt` for the move selector")
+}
+
+func newSolveFlagSet(name string, f *solveFlags) *flag.FlagSet {
+    fs := flag.NewFlagSet(name, flag.ExitOnError)
+    fs.StringVar(&f.cpuProfile, "cpu-profile", "", "write cpu profile to `file`")
+    fs.StringVar(&f.traceLog, "trace-log", "", "write trace log to `file`")
+    fs.BoolVar(&f.debug, "debug", false, "show debug info")
+    fs.StringVar(&f.duration, "duration", "", "max execution time for example 500ms or 60s")
+    registerSolverTuningFlags(fs, &f.replicas, &f.swapInterval, &f.moveMix)
+    // -workers is an alias for -replicas: since Resolve became a replica-exchange
+    // solver, the pool of worker goroutines and the number of replicas are the
+    // same thing, and per-worker RNGs are derived one per replica (see -seed).
+    fs.IntVar(&f.replicas, "workers", f.replicas, "alias for -replicas: number of solver worker goroutines")
+    fs.StringVar(&f.output, "output", "text", "result format: text or json")
+    fs.StringVar(&f.metricsAddr, "metrics-addr", "", "if set, serve Prometheus metrics and /debug/pprof on this address")
+    fs.StringVar(&f.energyLog, "energy-log", "", "if set, append a CSV energy time series to `file`")
+    registerSeedFlag(fs, &f.seed)
+    return fs
+}
+
+// active* hold the flags of the currently running solve, so the solver
+// internals (Move, Resolve, ...) can keep reading them as before.
+var activeFlags = &solveFlags{output: "text", replicas: 8, swapInterval: 50, moveMix: "1,1,1,1"}
+var debug = &activeFlags.debug
+var duration = &activeFlags.duration
+var replicas = &activeFlags.replicas
+var swapInterval = &activeFlags.swapInterval
+
+// metricsSampleStride limits how often the hot solver loops touch the
+// Prometheus metrics, so instrumentation doesn't dominate the step cost.
+const metricsSampleStride = 50
+
+var (
+    stepsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "tsp_steps_total",
+        Help: "Total number of solver moves attempted.",
+    })
+    acceptsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "tsp_accepts_total",
+        Help: "Total number of solver moves accepted.",
+    })
+    currentEnergyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "tsp_current_energy",
+        Help: "Total cost of the current route of the coldest replica.",
+    })
+    bestEnergyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "tsp_best_energy",
+        Help: "Total cost of the best route found so far.",
+    })
+    temperatureGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+        Name: "tsp_temperature",
+        Help: "Current annealing temperature of the coldest replica.",
+    })
+    swapSuccessTotal = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "tsp_swap_success_total",
+        Help: "Total number of accepted replica-exchange swaps.",
+    })
+)
+
+func init() {
+    prometheus.MustRegister(stepsTotal, acceptsTotal, currentEnergyGauge, bestEnergyGauge, temperatureGauge, swapSuccessTotal)
+}
+
+// startMetricsServer serves /metrics and /debug/pprof on addr in the
+// background. It never returns; a failure just logs, since metrics are a
+// diagnostic side channel and must not abort a solve in progress.
+func startMetricsServer(addr string) {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    mux.Handle("/debug/pprof/", http.DefaultServeMux)
+    go func() {
+        if err := http.ListenAndServe(addr, mux); err != nil {
+            log.Println("metrics server:", err)
+        }
+    }()
+}
+
+// energyLogger appends a compact CSV time series of the cooling schedule:
+// timestamp, temperature, current energy, best energy, accepts, improves.
+type energyLogger struct {
+    w     *csv.Writer
+    file  *os.File
+    lock  sync.Mutex
+}
+
+func newEnergyLogger(path string) (*energyLogger, error) {
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    w := csv.NewWriter(f)
+    if err := w.Write([]string{"timestamp_ms", "temperature", "current_energy", "best_energy", "accepts", "improves"}); err != nil {
+        f.Close()
+        return nil, err
+    }
+    w.Flush()
+    return &energyLogger{w: w, file: f}, nil
+}
+
+func (e *energyLogger) Log(startTime time.Time, T float64, currentEnergy, bestEnergy, accepts, improves int) {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    e.w.Write([]string{
+        strconv.FormatInt(time.Since(startTime).Milliseconds(), 10),
+        strconv.FormatFloat(T, 'f', 2, 64),
+        strconv.Itoa(currentEnergy),
+        strconv.Itoa(bestEnergy),
+        strconv.Itoa(accepts),
+        strconv.Itoa(improves),
+    })
+    e.w.Flush()
+}
+
+func (e *energyLogger) Close() {
+    e.w.Flush()
+    e.file.Close()
+}
 
 func showDebugMessage(msg string) {
     if *debug == true {
@@ -163,7 +318,7 @@ func NewCosts() Costs {
 }
 
 func (c *Costs) CreateKey(from string, to string, day int) string {
-    return from + "@:@" + to + "@:@" + string(day)
+    return from + "@:@" + to + "@:@" + strconv.Itoa(day)
 }
 
 func (c *Costs) AddCost(from string, to string, day int, cost int) {
@@ -269,7 +424,7 @@ func parseInput(r io.Reader) (int, int, string, Costs, Regions) {
     return totalRegionsCount, visitRegionsCount, startRegion, costs, regionGroups
 }
 
-func createInitialState(regions Regions, costs Costs, startRegion string) *Route {
+func createInitialState(regions Regions, costs Costs, startRegion string, rng *rand.Rand) *Route {
     route := NewRoute(costs)
     route.AddRegion(startRegion)
 
@@ -282,7 +437,7 @@ func createInitialState(regions Regions, costs Costs, startRegion string) *Route
             }
         }
         if isStartLocation == false {
-            route.AddRegion(regions[rand.Intn(len(regions))])
+            route.AddRegion(regions[rng.Intn(len(regions))])
         }
     }
 
@@ -291,10 +446,27 @@ func createInitialState(regions Regions, costs Costs, startRegion string) *Route
     return route
 }
 
-func renderOutput(route *Route, costs Costs) {
+// Hop is a single day of a solved route, used by the JSON output format.
+type Hop struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+    Day  int    `json:"day"`
+    Cost int    `json:"cost"`
+}
+
+// SolveResult is the JSON representation of a finished solve, also used
+// internally as the per-file record for the "benchmark" subcommand.
+type SolveResult struct {
+    TotalCost int   `json:"total_cost"`
+    Hops      []Hop `json:"hops"`
+    Steps     int   `json:"steps"`
+    ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+func buildSolveResult(route *Route, costs Costs, steps int, elapsedMs int64) SolveResult {
     state := route.regions
 
-    var paths []string
+    var hops []Hop
     totalCost := 0
 
     from := state[0]
@@ -304,14 +476,33 @@ func renderOutput(route *Route, costs Costs) {
             cost = -1
         }
 
-        paths = append(paths, fmt.Sprintf("%s %s %d %d", from, state[i], i, cost))
+        hops = append(hops, Hop{From: from, To: state[i], Day: i, Cost: cost})
         from = state[i]
         totalCost = totalCost + cost
     }
 
-    fmt.Println(totalCost)
-    for _, s := range paths {
-        fmt.Println(s)
+    return SolveResult{
+        TotalCost: totalCost,
+        Hops:      hops,
+        Steps:     steps,
+        ElapsedMs: elapsedMs,
+    }
+}
+
+func renderOutput(result SolveResult, output string) {
+    if output == "json" {
+        data, err := json.Marshal(result)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "rendering result:", err)
+            return
+        }
+        fmt.Println(string(data))
+        return
+    }
+
+    fmt.Println(result.TotalCost)
+    for _, h := range result.Hops {
+        fmt.Println(fmt.Sprintf("%s %s %d %d", h.From, h.To, h.Day, h.Cost))
     }
 }
 
@@ -335,9 +526,50 @@ type TravellingSalesmanProblem struct {
     regions      Regions
     endTime      time.Time
     hasMultiZone bool
+    steps        int
+    maxSteps     int
+    logStart     time.Time
+    energyLog    *energyLogger
+    rng          *rand.Rand
+    seed         int64
+    weights      moveWeights
 }
 
-func NewTravellingSalesmanProblem(initialState *Route, costs Costs, regions Regions, hasMultiZone bool) TravellingSalesmanProblem {
+// moveWeights holds the relative odds Move gives each neighbourhood operator.
+// Zero-valued by default, so callers must assign it (see parseMoveMix).
+type moveWeights struct {
+    swapZone   float64
+    swapRegion float64
+    twoOpt     float64
+    orOpt      float64
+}
+
+// parseMoveMix parses the -move-mix flag, a comma-separated list of four
+// non-negative weights in the order swap-zone,swap-region,two-opt,or-opt.
+func parseMoveMix(s string) (moveWeights, error) {
+    parts := strings.Split(s, ",")
+    if len(parts) != 4 {
+        return moveWeights{}, fmt.Errorf("move-mix: expected 4 comma-separated weights, got %d", len(parts))
+    }
+    values := make([]float64, 4)
+    for i, p := range parts {
+        v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+        if err != nil {
+            return moveWeights{}, fmt.Errorf("move-mix: invalid weight %q: %w", p, err)
+        }
+        if v < 0 {
+            return moveWeights{}, fmt.Errorf("move-mix: weight %q must not be negative", p)
+        }
+        values[i] = v
+    }
+    return moveWeights{swapZone: values[0], swapRegion: values[1], twoOpt: values[2], orOpt: values[3]}, nil
+}
+
+// NewTravellingSalesmanProblem builds a problem instance seeded off
+// masterSeed; rng (masterSeed^0) drives the single-threaded stages
+// (createInitialState, auto, the Resolve coordinator), while Resolve
+// derives one rng per replica as masterSeed^(workerIndex+1).
+func NewTravellingSalesmanProblem(initialState *Route, costs Costs, regions Regions, hasMultiZone bool, rng *rand.Rand, masterSeed int64) TravellingSalesmanProblem {
     var endTime time.Time
     t := TravellingSalesmanProblem{
         25000.0,
@@ -347,6 +579,13 @@ func NewTravellingSalesmanProblem(initialState *Route, costs Costs, regions Regi
         regions,
         endTime,
         hasMultiZone,
+        0,
+        0,
+        time.Time{},
+        nil,
+        rng,
+        masterSeed,
+        moveWeights{swapZone: 1, swapRegion: 1, twoOpt: 1, orOpt: 1},
     }
     return t
 }
@@ -355,12 +594,12 @@ func (t *TravellingSalesmanProblem) CountEnergy() int {
     return t.state.totalCost
 }
 
-func (t *TravellingSalesmanProblem) SwapZone(route *Route) {
+func (t *TravellingSalesmanProblem) SwapZone(route *Route, rng *rand.Rand) {
     // Swaps two cities in the route. Do not move first region and last
     regionsCount := len(route.regions)
     for r := 1; r <= 10; r++ {
-        ai := rand.Intn(regionsCount-2) + 1
-        bi := rand.Intn(regionsCount-2) + 1
+        ai := rng.Intn(regionsCount-2) + 1
+        bi := rng.Intn(regionsCount-2) + 1
         if ai > bi {
             ai, bi = bi, ai
         }
@@ -389,10 +628,10 @@ func (t *TravellingSalesmanProblem) SwapZone(route *Route) {
     }
 }
 
-func (t *TravellingSalesmanProblem) SwapRegionInZone(route *Route) {
+func (t *TravellingSalesmanProblem) SwapRegionInZone(route *Route, rng *rand.Rand) {
     for r := 1; r <= 10; r++ {
         // do no change first zone
-        a := rand.Intn(len(route.regions)-1) + 1
+        a := rng.Intn(len(route.regions)-1) + 1
         region := route.regions[a]
         regions := t.regions.GetAllRegions(region)
         newRegion := ""
@@ -405,7 +644,7 @@ func (t *TravellingSalesmanProblem) SwapRegionInZone(route *Route) {
                 newRegion = regions[1]
             }
         } else if count > 2 {
-            newRegion = regions[rand.Intn(len(regions))]
+            newRegion = regions[rng.Intn(len(regions))]
             if newRegion == region {
                 continue
             }
@@ -423,88 +662,295 @@ func (t *TravellingSalesmanProblem) SwapRegionInZone(route *Route) {
     }
 }
 
-func (t *TravellingSalesmanProblem) Move(step int, route *Route) {
-    if t.hasMultiZone {
-        if step%2 == 0 {
-            t.SwapZone(route)
+// tryRearrange replaces route.regions[lo:hi+1] with newSegment, but only if
+// every edge the change touches (lo-1..lo through hi..hi+1) resolves to a
+// known cost; otherwise route is left untouched and it returns false. Since
+// Route.Change always recomputes costs off the route's *current* contents,
+// applying it left to right telescopes the per-edge adds/removes down to
+// exactly the edges that actually change, same as a single Change call does
+// for a one-region substitution.
+func (t *TravellingSalesmanProblem) tryRearrange(route *Route, lo, hi int, newSegment []string) bool {
+    finalAt := func(k int) string {
+        if k < lo || k > hi {
+            return route.regions[k]
+        }
+        return newSegment[k-lo]
+    }
+
+    last := hi + 1
+    if last > len(route.regions)-1 {
+        last = len(route.regions) - 1
+    }
+    for k := lo; k <= last; k++ {
+        if _, ok := t.costs.GetCost(finalAt(k-1), finalAt(k), k); ok != nil {
+            return false
+        }
+    }
+
+    for k := lo; k <= hi; k++ {
+        route.Change(newSegment[k-lo], k)
+    }
+    return true
+}
+
+// TwoOpt reverses a random subsequence route.regions[i:j+1], the classic
+// 2-opt move. Costs here are directional and day-dependent, so (unlike
+// symmetric TSP 2-opt) every edge inside the reversed span can change, not
+// just its two ends; tryRearrange recomputes exactly that span and aborts
+// the move if it would introduce an unknown-cost edge.
+func (t *TravellingSalesmanProblem) TwoOpt(route *Route, rng *rand.Rand) {
+    regionsCount := len(route.regions)
+    if regionsCount < 4 {
+        return
+    }
+    for r := 1; r <= 10; r++ {
+        i := rng.Intn(regionsCount-2) + 1
+        j := rng.Intn(regionsCount-2) + 1
+        if i > j {
+            i, j = j, i
+        }
+        if i == j {
+            continue
+        }
+
+        segment := make([]string, j-i+1)
+        for k := i; k <= j; k++ {
+            segment[j-k] = route.regions[k]
+        }
+        if t.tryRearrange(route, i, j, segment) {
+            break
+        }
+    }
+}
+
+// OrOpt relocates a random contiguous block of blockLen regions (1-3) to a
+// random other position in the route. Do not move the first or last region.
+func (t *TravellingSalesmanProblem) OrOpt(route *Route, blockLen int, rng *rand.Rand) {
+    if blockLen < 1 {
+        blockLen = 1
+    }
+    if blockLen > 3 {
+        blockLen = 3
+    }
+    regionsCount := len(route.regions)
+    if regionsCount < blockLen+3 {
+        return
+    }
+
+    span := regionsCount - 1 - blockLen
+    for r := 1; r <= 10; r++ {
+        from := rng.Intn(span) + 1
+        to := rng.Intn(span) + 1
+        if to >= from && to < from+blockLen {
+            continue
+        }
+
+        block := append([]string(nil), route.regions[from:from+blockLen]...)
+
+        var lo, hi int
+        var segment []string
+        if to < from {
+            lo, hi = to, from+blockLen-1
+            rest := append([]string(nil), route.regions[to:from]...)
+            segment = append(append([]string(nil), block...), rest...)
         } else {
-            t.SwapRegionInZone(route)
+            lo, hi = from, to+blockLen-1
+            rest := append([]string(nil), route.regions[from+blockLen:to+blockLen]...)
+            segment = append(append([]string(nil), rest...), block...)
+        }
+
+        if t.tryRearrange(route, lo, hi, segment) {
+            break
         }
-    } else {
-        t.SwapZone(route)
     }
 }
 
-func (t *TravellingSalesmanProblem) Resolve() *Route {
-    bestState := NewBestRoute(t.state.Copy())
-    startTime := time.Now()
-    duration := t.endTime.Sub(startTime)
+func (t *TravellingSalesmanProblem) Move(step int, route *Route, rng *rand.Rand) {
+    swapRegionWeight := t.weights.swapRegion
+    if !t.hasMultiZone {
+        swapRegionWeight = 0
+    }
+    total := t.weights.swapZone + swapRegionWeight + t.weights.twoOpt + t.weights.orOpt
+    if total <= 0 {
+        t.SwapZone(route, rng)
+        return
+    }
 
-    step := 0
+    pick := rng.Float64() * total
+    switch {
+    case pick < t.weights.swapZone:
+        t.SwapZone(route, rng)
+    case pick < t.weights.swapZone+swapRegionWeight:
+        t.SwapRegionInZone(route, rng)
+    case pick < t.weights.swapZone+swapRegionWeight+t.weights.twoOpt:
+        t.TwoOpt(route, rng)
+    default:
+        t.OrOpt(route, 1+rng.Intn(3), rng)
+    }
+}
 
-    resolve := func(currentState *Route, wg *sync.WaitGroup) {
-        Tfactor := math.Log(t.Tmax/t.Tmin) * -1
+// replica is one parallel-tempering chain: a fixed-temperature random walk
+// over the route space, exchanged with its neighbours by the coordinator.
+type replica struct {
+    state    *Route
+    prev     *Route
+    T        float64
+    energy   int
+    accepts  int
+    improves int
+    rng      *rand.Rand
+}
 
-        T := t.Tmax
-        prevState := currentState.Copy()
-        localBestEnergy := currentState.totalCost
+// replicaTemperatures returns K temperatures geometrically spaced between
+// Tmax and Tmin, i.e. T_i = Tmax*(Tmin/Tmax)^(i/(K-1)).
+func replicaTemperatures(Tmax, Tmin float64, k int) []float64 {
+    temps := make([]float64, k)
+    if k == 1 {
+        temps[0] = Tmax
+        return temps
+    }
+    ratio := Tmin / Tmax
+    for i := 0; i < k; i++ {
+        temps[i] = Tmax * math.Pow(ratio, float64(i)/float64(k-1))
+    }
+    return temps
+}
 
-        currentTime := time.Now()
-        stop := false
-        for stop == false {
-            if step%1000 == 0 {
-                currentTime = time.Now()
-                if t.endTime.Sub(currentTime) < 0 {
-                    stop = true
-                }
-            }
+// resolveStepsPerMs is the deterministic step-budget rate stepsForDuration
+// uses to turn a wall-clock -duration into a fixed Resolve() step count. The
+// exact rate doesn't need to match real solver throughput; it only needs to
+// be constant, so that a given (-seed, -duration) pair always drives the RNG
+// streams through the same number of Move calls regardless of machine speed
+// or scheduling jitter.
+const resolveStepsPerMs = 400
+
+// stepsForDuration converts d into a step budget for TravellingSalesmanProblem.maxSteps,
+// rounded up to a whole number of swap-exchange rounds so Resolve always stops
+// on a round boundary.
+func stepsForDuration(d time.Duration, swapInterval int) int {
+    steps := int(d.Milliseconds()) * resolveStepsPerMs
+    if swapInterval < 1 {
+        swapInterval = 1
+    }
+    rounds := (steps + swapInterval - 1) / swapInterval
+    if rounds < 1 {
+        rounds = 1
+    }
+    return rounds * swapInterval
+}
 
-            T = t.Tmax * math.Exp(Tfactor*float64(currentTime.Sub(startTime))/float64(duration))
-            t.Move(step, currentState)
-            dE := currentState.totalCost - prevState.totalCost
+func (t *TravellingSalesmanProblem) Resolve() *Route {
+    bestState := NewBestRoute(t.state.Copy())
 
-            if currentState.totalCost <= bestState.route.totalCost {
-                bestState.Lock()
-                routePool.Put(bestState.route)
-                bestState.route = currentState.Copy()
-                bestState.Unlock()
-                localBestEnergy = currentState.totalCost
-            }
+    k := *replicas
+    if k < 1 {
+        k = 1
+    }
+    temps := replicaTemperatures(t.Tmax, t.Tmin, k)
+
+    reps := make([]*replica, k)
+    for i := 0; i < k; i++ {
+        state := t.state.Copy()
+        reps[i] = &replica{
+            state:  state,
+            prev:   state.Copy(),
+            T:      temps[i],
+            energy: state.totalCost,
+            rng:    rand.New(rand.NewSource(t.seed ^ int64(i+1))),
+        }
+    }
 
-            if dE > 0.0 && math.Exp((float64(dE)*-1)/T) < rand.Float64() {
-                // restore prev state
-                routePool.Put(currentState)
-                currentState = prevState.Copy()
-            } else {
-                // accept new state
-                routePool.Put(prevState)
-                prevState = currentState.Copy()
+    step := 0
+    stop := false
+    for stop == false {
+        wg := sync.WaitGroup{}
+        for i := 0; i < k; i++ {
+            wg.Add(1)
+            go func(rep *replica, isColdest bool) {
+                rep.accepts, rep.improves = 0, 0
+                for s := 0; s < *swapInterval; s++ {
+                    t.Move(step+s, rep.state, rep.rng)
+                    dE := rep.state.totalCost - rep.prev.totalCost
+
+                    if isColdest && rep.state.totalCost <= bestState.route.totalCost {
+                        bestState.Lock()
+                        routePool.Put(bestState.route)
+                        bestState.route = rep.state.Copy()
+                        bestState.Unlock()
+                    }
+
+                    if dE > 0.0 && math.Exp((float64(dE)*-1)/rep.T) < rep.rng.Float64() {
+                        // restore prev state
+                        routePool.Put(rep.state)
+                        rep.state = rep.prev.Copy()
+                    } else {
+                        // accept new state
+                        routePool.Put(rep.prev)
+                        rep.prev = rep.state.Copy()
+                        rep.accepts++
+                        if dE < 0.0 {
+                            rep.improves++
+                        }
+                    }
+                }
+                rep.energy = rep.state.totalCost
+                wg.Done()
+            }(reps[i], i == k-1)
+        }
+        wg.Wait()
+
+        step += *swapInterval
+
+        // coordinator: attempt a Metropolis swap between each adjacent pair
+        swapped := 0
+        for i := 0; i < k-1; i++ {
+            a, b := reps[i], reps[i+1]
+            dE := float64(a.energy - b.energy)
+            p := math.Exp(dE * (1/a.T - 1/b.T))
+            if p >= 1.0 || t.rng.Float64() < p {
+                a.state, b.state = b.state, a.state
+                a.prev, b.prev = b.prev, a.prev
+                a.energy, b.energy = b.energy, a.energy
+                swapped++
             }
+        }
 
-            // sync gorountine best state
-            if localBestEnergy > bestState.route.totalCost {
-                routePool.Put(currentState)
-                bestState.Lock()
-                currentState = bestState.route.Copy()
-                bestState.Unlock()
-                localBestEnergy = currentState.totalCost
-            }
+        // reps[k-1] holds Tmin (see replicaTemperatures): the metrics gauges
+        // and energy-log rows below must read the actual coldest chain, since
+        // that's the chain Tmax/Tmin auto-tuning is meant to be judged by.
+        coldest := reps[k-1]
+        stepsTotal.Add(float64(k) * float64(*swapInterval))
+        for _, rep := range reps {
+            acceptsTotal.Add(float64(rep.accepts))
+        }
+        swapSuccessTotal.Add(float64(swapped))
+        currentEnergyGauge.Set(float64(coldest.energy))
+        bestEnergyGauge.Set(float64(bestState.route.totalCost))
+        temperatureGauge.Set(coldest.T)
+        if t.energyLog != nil {
+            t.energyLog.Log(t.logStart, coldest.T, coldest.energy, bestState.route.totalCost, coldest.accepts, coldest.improves)
+        }
 
-            step++
+        if t.maxSteps > 0 {
+            // Deterministic step budget (see stepsForDuration): set whenever
+            // -seed is non-zero, so the run terminates after exactly the same
+            // number of per-replica Move calls every time instead of racing
+            // the wall clock.
+            stop = step >= t.maxSteps
+        } else if step%1000 < *swapInterval {
+            if t.endTime.Sub(time.Now()) < 0 {
+                stop = true
+            }
         }
-        routePool.Put(currentState)
-        wg.Done()
     }
 
-    workersCount := runtime.NumCPU()
-    wg := sync.WaitGroup{}
-    for i := 1; i <= workersCount; i++ {
-        wg.Add(1)
-        go resolve(t.state.Copy(), &wg)
+    for _, rep := range reps {
+        routePool.Put(rep.state)
+        routePool.Put(rep.prev)
     }
-    wg.Wait()
 
     showDebugMessage(fmt.Sprintf("Steps: %d", step))
+    t.steps = step
     t.state = bestState.route
     return t.state
 }
@@ -519,7 +965,7 @@ func (t *TravellingSalesmanProblem) auto(steps int) (float64, float64) {
         prevEnergy := E
         accepts, improves := 0, 0
         for i := 1; i <= steps; i++ {
-            t.Move(i, t.state)
+            t.Move(i, t.state, t.rng)
             E = t.CountEnergy()
             dE := E - prevEnergy
 
@@ -529,7 +975,7 @@ func (t *TravellingSalesmanProblem) auto(steps int) (float64, float64) {
                 bestEnergy = E
             }
 
-            if dE > 0.0 && math.Exp((float64(dE)*-1)/T) < float64(float64(rand.Intn(100000))/100000) {
+            if dE > 0.0 && math.Exp((float64(dE)*-1)/T) < float64(float64(t.rng.Intn(100000))/100000) {
                 routePool.Put(t.state)
                 t.state = prevState.Copy()
                 E = prevEnergy
@@ -542,6 +988,18 @@ func (t *TravellingSalesmanProblem) auto(steps int) (float64, float64) {
                 prevState = t.state.Copy()
                 prevEnergy = E
             }
+
+            if i%metricsSampleStride == 0 {
+                stepsTotal.Add(metricsSampleStride)
+                currentEnergyGauge.Set(float64(E))
+                bestEnergyGauge.Set(float64(bestEnergy))
+                temperatureGauge.Set(T)
+            }
+        }
+        stepsTotal.Add(float64(steps % metricsSampleStride))
+        acceptsTotal.Add(float64(accepts))
+        if t.energyLog != nil {
+            t.energyLog.Log(t.logStart, T, E, bestEnergy, accepts, improves)
         }
         return E, float64(accepts) / float64(steps), float64(improves) / float64(steps)
     }
@@ -552,7 +1010,7 @@ func (t *TravellingSalesmanProblem) auto(steps int) (float64, float64) {
     E := t.CountEnergy()
     for T == 0.0 {
         step++
-        t.Move(step, t.state)
+        t.Move(step, t.state, t.rng)
         T = math.Abs(float64(t.CountEnergy()) - float64(E))
     }
 
@@ -583,16 +1041,82 @@ func (t *TravellingSalesmanProblem) auto(steps int) (float64, float64) {
     return Tmax, Tmin
 }
 
-func main() {
-    runtime.GOMAXPROCS(runtime.NumCPU())
-    rand.Seed(time.Now().UnixNano())
-    var startTime = time.Now()
+// solveOnce runs one end-to-end solve against input using the given flags
+// and returns the structured result. It is shared by the "solve" subcommand
+// (stdin) and the "benchmark" subcommand (one call per input file).
+func solveOnce(input io.Reader, durationOverride string, eLog *energyLogger, seed int64) (SolveResult, error) {
+    startTime := time.Now()
 
-    initRoutePool()
+    masterSeed := seed
+    if masterSeed == 0 {
+        masterSeed = time.Now().UnixNano()
+    }
+    mainRng := rand.New(rand.NewSource(masterSeed ^ 0))
+
+    pt := time.Now()
+    totalRegionsCount, zones, startRegion, costs, regions := parseInput(input)
+    showExecutionTime("File Parse time", pt)
+
+    initialState := createInitialState(regions, costs, startRegion, mainRng)
+
+    hasMultiRegion := totalRegionsCount > zones
+    T := NewTravellingSalesmanProblem(initialState, costs, regions, hasMultiRegion, mainRng, masterSeed)
+    T.energyLog = eLog
+    // Both auto() and Resolve() log to the same energyLog, so they must share
+    // one timestamp origin: the start of the whole solve, not their own phase
+    // start. Otherwise timestamp_ms resets to ~0 at the auto->resolve
+    // boundary and the cooling-curve plot goes non-monotonic.
+    T.logStart = startTime
+    if weights, err := parseMoveMix(activeFlags.moveMix); err == nil {
+        T.weights = weights
+    } else {
+        showDebugMessage(fmt.Sprintf("ignoring invalid -move-mix %q: %v", activeFlags.moveMix, err))
+    }
+    var maxDuration string
+    if durationOverride != "" {
+        maxDuration = durationOverride
+    } else if zones <= 20 && totalRegionsCount < 50 {
+        maxDuration = "1300ms"
+    } else if zones <= 100 && totalRegionsCount < 200 {
+        maxDuration = "4800ms"
+    } else {
+        maxDuration = "14700ms"
+    }
 
-    flag.Parse()
-    if *cpuProfile != "" {
-        f, err := os.Create(*cpuProfile)
+    dur, err := time.ParseDuration(maxDuration)
+    if err != nil {
+        return SolveResult{}, err
+    }
+    T.endTime = startTime.Add(dur)
+    if seed != 0 {
+        // Reproducibility was explicitly requested: drive Resolve off a fixed
+        // step budget instead of wall-clock, so repeated runs with the same
+        // (seed, duration, workers, input) consume each replica's *rand.Rand
+        // stream exactly the same number of times and produce the same route.
+        T.maxSteps = stepsForDuration(dur, activeFlags.swapInterval)
+    }
+
+    T.Tmax, T.Tmin = T.auto(2000)
+    rt := time.Now()
+    resultState := T.Resolve()
+    showExecutionTime("Resolve time", rt)
+
+    showDebugMessage(fmt.Sprintf("Tmin: %f, Tmax %f", T.Tmin, T.Tmax))
+    showExecutionTime("Total time", startTime)
+    showDebugMessage(fmt.Sprintf("Total zones: %d, Visised %d", totalRegionsCount, zones))
+
+    elapsedMs := int64(time.Since(startTime) / time.Millisecond)
+    return buildSolveResult(resultState, costs, T.steps, elapsedMs), nil
+}
+
+// runSolveCommand reproduces the historical stdin/stdout behaviour of the
+// binary, with an optional `-output json` mode for machine consumption.
+func runSolveCommand(args []string) {
+    fs := newSolveFlagSet("solve", activeFlags)
+    fs.Parse(args)
+
+    if activeFlags.cpuProfile != "" {
+        f, err := os.Create(activeFlags.cpuProfile)
         if err != nil {
             log.Fatal("could not create CPU profile: ", err)
         }
@@ -602,61 +1126,220 @@ func main() {
         defer pprof.StopCPUProfile()
     }
 
-    if *traceLog != "" {
-        f, err := os.Create(*traceLog)
+    if activeFlags.traceLog != "" {
+        f, err := os.Create(activeFlags.traceLog)
         if err != nil {
             panic(err)
         }
         defer f.Close()
 
-        err = trace.Start(f)
-        if err != nil {
+        if err := trace.Start(f); err != nil {
             panic(err)
         }
         defer trace.Stop()
     }
 
-    var file = os.Stdin
-    //file, _ := os.Open("data/0.in.txt")
+    if activeFlags.metricsAddr != "" {
+        startMetricsServer(activeFlags.metricsAddr)
+    }
 
-    //file, _ := os.Open("data/1.in.txt")
-    //file, _ := os.Open("data/2.in.txt")
-    //file, _ := os.Open("data/3.in.txt")
-    //file, _ := os.Open("data/4.in.txt")
+    var eLog *energyLogger
+    if activeFlags.energyLog != "" {
+        var err error
+        eLog, err = newEnergyLogger(activeFlags.energyLog)
+        if err != nil {
+            log.Fatal("could not open energy log: ", err)
+        }
+        defer eLog.Close()
+    }
 
-    pt := time.Now()
-    totalRegionsCount, zones, startRegion, costs, regions := parseInput(file)
-    showExecutionTime("File Parse time", pt)
+    result, err := solveOnce(os.Stdin, activeFlags.duration, eLog, activeFlags.seed)
+    if err != nil {
+        panic(err)
+    }
 
-    initialState := createInitialState(regions, costs, startRegion)
+    renderOutput(result, activeFlags.output)
+}
 
-    hasMultiRegion := totalRegionsCount > zones
-    T := NewTravellingSalesmanProblem(initialState, costs, regions, hasMultiRegion)
-    var maxDuration string
-    if *duration != "" {
-        maxDuration = *duration
-    } else if zones <= 20 && totalRegionsCount < 50 {
-        maxDuration = "1300ms"
-    } else if zones <= 100 && totalRegionsCount < 200 {
-        maxDuration = "4800ms"
-    } else {
-        maxDuration = "14700ms"
+// fileRecord is one line of a "benchmark" report: a solve result keyed by
+// the input file it came from, or the error that input produced.
+type fileRecord struct {
+    File string `json:"file"`
+    SolveResult
+    Error string `json:"error,omitempty"`
+}
+
+// aggregateStats summarizes a batch of fileRecords for regression tracking.
+type aggregateStats struct {
+    Count           int     `json:"count"`
+    MeanCost        float64 `json:"mean_cost"`
+    MedianCost      float64 `json:"median_cost"`
+    P95Cost         float64 `json:"p95_cost"`
+    MeanElapsedMs   float64 `json:"mean_elapsed_ms"`
+    MedianElapsedMs float64 `json:"median_elapsed_ms"`
+    P95ElapsedMs    float64 `json:"p95_elapsed_ms"`
+    MeanSteps       float64 `json:"mean_steps"`
+}
+
+// BenchmarkReport is the JSON document a "benchmark" run emits, and the
+// document "analyze" consumes to compare runs.
+type BenchmarkReport struct {
+    Files     []fileRecord   `json:"files"`
+    Aggregate aggregateStats `json:"aggregate"`
+}
+
+func mean(xs []float64) float64 {
+    if len(xs) == 0 {
+        return 0
+    }
+    sum := 0.0
+    for _, x := range xs {
+        sum += x
+    }
+    return sum / float64(len(xs))
+}
+
+func percentile(xs []float64, p float64) float64 {
+    if len(xs) == 0 {
+        return 0
+    }
+    sorted := append([]float64(nil), xs...)
+    sort.Float64s(sorted)
+    idx := int(p * float64(len(sorted)-1))
+    return sorted[idx]
+}
+
+func aggregateRecords(records []fileRecord) aggregateStats {
+    var costs, elapsed, steps []float64
+    for _, r := range records {
+        if r.Error != "" {
+            continue
+        }
+        costs = append(costs, float64(r.TotalCost))
+        elapsed = append(elapsed, float64(r.ElapsedMs))
+        steps = append(steps, float64(r.Steps))
+    }
+    return aggregateStats{
+        Count:           len(costs),
+        MeanCost:        mean(costs),
+        MedianCost:      percentile(costs, 0.5),
+        P95Cost:         percentile(costs, 0.95),
+        MeanElapsedMs:   mean(elapsed),
+        MedianElapsedMs: percentile(elapsed, 0.5),
+        P95ElapsedMs:    percentile(elapsed, 0.95),
+        MeanSteps:       mean(steps),
+    }
+}
+
+// runBenchmarkCommand solves every input file in a directory with a shared
+// per-file time budget and prints a BenchmarkReport to stdout.
+func runBenchmarkCommand(args []string) {
+    fs := flag.NewFlagSet("benchmark", flag.ExitOnError)
+    dir := fs.String("dir", "", "directory of input files to benchmark")
+    budget := fs.String("budget", "", "per-file time budget, for example 2s (overrides auto-sizing)")
+    var seed int64
+    registerSeedFlag(fs, &seed)
+    registerSolverTuningFlags(fs, &activeFlags.replicas, &activeFlags.swapInterval, &activeFlags.moveMix)
+    fs.Parse(args)
+
+    if *dir == "" {
+        log.Fatal("benchmark: -dir is required")
     }
 
-    duration, err := time.ParseDuration(maxDuration)
+    entries, err := ioutil.ReadDir(*dir)
     if err != nil {
-        panic(err)
+        log.Fatal(err)
     }
-    T.endTime = startTime.Add(duration)
 
-    T.Tmax, T.Tmin = T.auto(2000)
-    rt := time.Now()
-    resultState := T.Resolve()
-    showExecutionTime("Resolve time", rt)
+    var records []fileRecord
+    for i, entry := range entries {
+        if entry.IsDir() {
+            continue
+        }
 
-    showDebugMessage(fmt.Sprintf("Tmin: %f, Tmax %f", T.Tmin, T.Tmax))
-    showExecutionTime("Total time", startTime)
-    showDebugMessage(fmt.Sprintf("Total zones: %d, Visised %d", totalRegionsCount, zones))
+        path := filepath.Join(*dir, entry.Name())
+        in, err := os.Open(path)
+        if err != nil {
+            records = append(records, fileRecord{File: entry.Name(), Error: err.Error()})
+            continue
+        }
 
-    renderOutput(resultState, costs)
+        fileSeed := int64(0)
+        if seed != 0 {
+            fileSeed = seed ^ int64(i)
+        }
+        result, err := solveOnce(in, *budget, nil, fileSeed)
+        in.Close()
+        if err != nil {
+            records = append(records, fileRecord{File: entry.Name(), Error: err.Error()})
+            continue
+        }
+
+        records = append(records, fileRecord{File: entry.Name(), SolveResult: result})
+    }
+
+    report := BenchmarkReport{Files: records, Aggregate: aggregateRecords(records)}
+    data, err := json.Marshal(report)
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(string(data))
+}
+
+// runAnalyzeCommand loads two or more BenchmarkReport files and prints their
+// aggregate stats side by side, so a schedule/move-mix change can be judged
+// against a baseline run.
+func runAnalyzeCommand(args []string) {
+    fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+    fs.Parse(args)
+    paths := fs.Args()
+
+    if len(paths) < 2 {
+        log.Fatal("analyze: need at least two benchmark JSON files to compare")
+    }
+
+    reports := make([]BenchmarkReport, len(paths))
+    for i, p := range paths {
+        data, err := ioutil.ReadFile(p)
+        if err != nil {
+            log.Fatal(err)
+        }
+        if err := json.Unmarshal(data, &reports[i]); err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    base := reports[0]
+    fmt.Printf("%-30s %12s %12s %12s\n", "run", "mean_cost", "mean_ms", "mean_steps")
+    fmt.Printf("%-30s %12.1f %12.1f %12.1f\n", filepath.Base(paths[0]), base.Aggregate.MeanCost, base.Aggregate.MeanElapsedMs, base.Aggregate.MeanSteps)
+    for i := 1; i < len(reports); i++ {
+        r := reports[i]
+        fmt.Printf("%-30s %12.1f %12.1f %12.1f  (delta cost %+.1f, delta ms %+.1f)\n",
+            filepath.Base(paths[i]), r.Aggregate.MeanCost, r.Aggregate.MeanElapsedMs, r.Aggregate.MeanSteps,
+            r.Aggregate.MeanCost-base.Aggregate.MeanCost, r.Aggregate.MeanElapsedMs-base.Aggregate.MeanElapsedMs)
+    }
+}
+
+func main() {
+    runtime.GOMAXPROCS(runtime.NumCPU())
+    initRoutePool()
+
+    cmd := "solve"
+    args := os.Args[1:]
+    if len(args) > 0 {
+        switch args[0] {
+        case "solve", "benchmark", "analyze":
+            cmd = args[0]
+            args = args[1:]
+        }
+    }
+
+    switch cmd {
+    case "benchmark":
+        runBenchmarkCommand(args)
+    case "analyze":
+        runAnalyzeCommand(args)
+    default:
+        runSolveCommand(args)
+    }
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "math/rand"
+    "testing"
+)
+
+// recomputeRouteCost independently sums the cost of every adjacent pair in
+// route.regions straight from costs, bypassing Route's incremental totalCost
+// bookkeeping entirely. It is the ground truth tryRearrange's telescoped
+// Change calls are checked against below.
+func recomputeRouteCost(t *testing.T, costs Costs, regions []string) int {
+    t.Helper()
+    total := 0
+    for i := 1; i < len(regions); i++ {
+        cost, err := costs.GetCost(regions[i-1], regions[i], i)
+        if err != nil {
+            t.Fatalf("recomputeRouteCost: no cost for %s->%s on day %d", regions[i-1], regions[i], i)
+        }
+        total += cost
+    }
+    return total
+}
+
+// newFullyConnectedProblem builds a TravellingSalesmanProblem over n regions
+// with a cost defined for every (from, to, day) triple, so tryRearrange never
+// rejects a move for an unknown edge.
+func newFullyConnectedProblem(n int) (*TravellingSalesmanProblem, []string) {
+    names := make([]string, n)
+    for i := range names {
+        names[i] = string(rune('A' + i))
+    }
+
+    costs := NewCosts()
+    for day := 1; day < n; day++ {
+        for _, from := range names {
+            for _, to := range names {
+                if from == to {
+                    continue
+                }
+                costs.AddCost(from, to, day, day*1000+len(from)*31+len(to)*7)
+            }
+        }
+    }
+
+    route := NewRoute(costs)
+    route.regions = append([]string(nil), names...)
+    route.totalCost = 0
+    for i := 1; i < len(route.regions); i++ {
+        cost, _ := costs.GetCost(route.regions[i-1], route.regions[i], i)
+        route.totalCost += cost
+    }
+
+    regionGroups := NewRegions()
+    regionGroups.AddRegion("zone", names)
+
+    tsp := NewTravellingSalesmanProblem(route, costs, regionGroups, false, rand.New(rand.NewSource(1)), 1)
+    return &tsp, names
+}
+
+// TestTwoOptOrOptIncrementalCostMatchesRecompute pins the invariant tryRearrange
+// relies on: applying Route.Change left to right for a 2-opt/Or-opt move must
+// leave totalCost equal to a full from-scratch recompute, not just "close".
+func TestTwoOptOrOptIncrementalCostMatchesRecompute(t *testing.T) {
+    initRoutePool()
+    tsp, _ := newFullyConnectedProblem(8)
+    rng := rand.New(rand.NewSource(42))
+
+    for i := 0; i < 200; i++ {
+        if i%2 == 0 {
+            tsp.TwoOpt(tsp.state, rng)
+        } else {
+            tsp.OrOpt(tsp.state, 1+rng.Intn(3), rng)
+        }
+
+        want := recomputeRouteCost(t, tsp.costs, tsp.state.regions)
+        if tsp.state.totalCost != want {
+            t.Fatalf("move %d: incremental totalCost = %d, recompute = %d (regions %v)",
+                i, tsp.state.totalCost, want, tsp.state.regions)
+        }
+    }
+}
+
+// TestTryRearrangeRejectsUnknownEdge checks the other half of tryRearrange's
+// contract: a proposed rearrangement that would introduce an edge with no
+// known cost must be rejected outright, leaving the route untouched.
+func TestTryRearrangeRejectsUnknownEdge(t *testing.T) {
+    initRoutePool()
+    tsp, names := newFullyConnectedProblem(6)
+
+    // Remove the one cost entry the reversal of regions[1:4] would need,
+    // i.e. the edge from the unchanged predecessor at index 0 (names[0]) to
+    // the region that ends up at index 1 (names[3]) on day 1. There is no
+    // fallback day-0 entry for this pair either, so GetCost must fail for it.
+    gapFrom, gapTo := names[0], names[3]
+    delete(tsp.costs.costs, tsp.costs.CreateKey(gapFrom, gapTo, 1))
+
+    before := append([]string(nil), tsp.state.regions...)
+    beforeCost := tsp.state.totalCost
+
+    ok := tsp.tryRearrange(tsp.state, 1, 3, []string{names[3], names[2], names[1]})
+    if ok {
+        t.Fatalf("tryRearrange accepted a move through an unknown edge %s->%s", gapFrom, gapTo)
+    }
+    if tsp.state.totalCost != beforeCost {
+        t.Fatalf("tryRearrange mutated totalCost on a rejected move: got %d, want %d", tsp.state.totalCost, beforeCost)
+    }
+    for i, r := range tsp.state.regions {
+        if r != before[i] {
+            t.Fatalf("tryRearrange mutated regions on a rejected move: got %v, want %v", tsp.state.regions, before)
+        }
+    }
+}